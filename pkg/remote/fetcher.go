@@ -0,0 +1,193 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package remote implements a conditional-GET, atomic-replace downloader
+// shared by the data_provider plugins (domain_set, ip_set, ...) that load
+// their matchers from a remotely hosted file refreshed on an interval.
+package remote
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Config is the common set of fields a remote-file-backed plugin exposes in
+// its YAML args. Plugins embed it (with `yaml:",inline"`) into their own
+// RemoteFile struct alongside any format-specific fields.
+type Config struct {
+	URL      string `yaml:"url"`
+	Path     string `yaml:"path"`
+	Interval int    `yaml:"interval"` // refresh interval, in seconds
+	Timeout  int    `yaml:"timeout"`  // http request timeout, in seconds. Default 30.
+	MaxBytes int64  `yaml:"max_bytes"`
+
+	// SHA256 is a literal hex digest the downloaded file must match. If
+	// empty and SHA256URL is set, the digest is instead fetched from
+	// SHA256URL (plain hex, optionally in "sha256sum(1)" output format).
+	SHA256    string `yaml:"sha256"`
+	SHA256URL string `yaml:"sha256_url"`
+
+	// MinisignPubKey (a minisign public key string) and SigURL (pointing at
+	// the corresponding ".minisig" signature) enable Ed25519 signature
+	// verification of the downloaded file.
+	MinisignPubKey string `yaml:"minisign_pub_key"`
+	SigURL         string `yaml:"sig_url"`
+
+	// MaxRetries is how many extra attempts FetchWithRetry makes after an
+	// initial failure, with exponential backoff between them. 0 (including
+	// an omitted field) means the default of 3; there is currently no way
+	// to explicitly request zero retries.
+	MaxRetries int `yaml:"max_retries"`
+	// Jitter is the fraction (e.g. 0.1 for ±10%) of Interval randomized on
+	// each refresh by RunScheduled, to avoid many instances refreshing the
+	// same URL in lockstep. 0 (including an omitted field) means the
+	// default of 0.1; there is currently no way to explicitly disable
+	// jitter.
+	Jitter float64 `yaml:"jitter"`
+}
+
+const defaultTimeout = 30 * time.Second
+
+func (c Config) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return time.Duration(c.Timeout) * time.Second
+	}
+	return defaultTimeout
+}
+
+// cacheMeta is the sidecar stored next to Path as "<path>.meta.json". It
+// records the validators from the previous successful download so the next
+// fetch can be conditional.
+type cacheMeta struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+func metaPath(path string) string {
+	return path + ".meta.json"
+}
+
+func loadCacheMeta(path string) *cacheMeta {
+	b, err := os.ReadFile(metaPath(path))
+	if err != nil {
+		return &cacheMeta{}
+	}
+	m := new(cacheMeta)
+	if err := json.Unmarshal(b, m); err != nil {
+		return &cacheMeta{}
+	}
+	return m
+}
+
+func saveCacheMeta(path string, m *cacheMeta) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(metaPath(path), b, 0644)
+}
+
+// Fetch conditionally downloads cfg.URL into cfg.Path. If the server
+// confirms the local copy is still fresh (HTTP 304, matched via the cached
+// ETag/Last-Modified), Fetch returns updated=false and leaves Path
+// untouched. Otherwise the response is written to a temp file and
+// atomically renamed over Path, so a reader calling LoadFile concurrently
+// never observes a partially written file.
+func Fetch(cfg Config) (updated bool, err error) {
+	if dir := filepath.Dir(cfg.Path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return false, fmt.Errorf("failed to create directory %s: %w", dir, err)
+		}
+	}
+
+	cached := loadCacheMeta(cfg.Path)
+	_, statErr := os.Stat(cfg.Path)
+	haveLocalCopy := statErr == nil
+
+	req, err := http.NewRequest(http.MethodGet, cfg.URL, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to build request: %w", err)
+	}
+	if haveLocalCopy {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	client := clientWithTimeout(cfg.timeout())
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to download file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("bad status: %s", resp.Status)
+	}
+
+	r := resp.Body
+	if cfg.MaxBytes > 0 {
+		r = http.MaxBytesReader(nil, resp.Body, cfg.MaxBytes)
+	}
+
+	tmp := cfg.Path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return false, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return false, fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return false, fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	// Verify integrity before the file is ever visible at cfg.Path. On
+	// failure the previous, already-verified copy at cfg.Path (and whatever
+	// matcher was built from it) is left completely untouched.
+	if err := verify(client, cfg, tmp); err != nil {
+		os.Remove(tmp)
+		return false, fmt.Errorf("integrity verification failed: %w", err)
+	}
+
+	if err := os.Rename(tmp, cfg.Path); err != nil {
+		os.Remove(tmp)
+		return false, fmt.Errorf("failed to replace %s: %w", cfg.Path, err)
+	}
+
+	saveCacheMeta(cfg.Path, &cacheMeta{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	})
+	return true, nil
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package remote
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultJitter     = 0.1
+	defaultMaxRetries = 3
+	retryBaseBackoff  = time.Second
+)
+
+// jitter returns c.Jitter, or defaultJitter if it's 0. See the Config.Jitter
+// doc comment: a zero value can't be distinguished from an omitted field, so
+// jitter can't currently be explicitly disabled this way.
+func (c Config) jitter() float64 {
+	if c.Jitter > 0 {
+		return c.Jitter
+	}
+	return defaultJitter
+}
+
+// maxRetries returns c.MaxRetries, or defaultMaxRetries if it's 0. See the
+// Config.MaxRetries doc comment: a zero value can't be distinguished from an
+// omitted field, so retries can't currently be explicitly disabled this way.
+func (c Config) maxRetries() int {
+	if c.MaxRetries > 0 {
+		return c.MaxRetries
+	}
+	return defaultMaxRetries
+}
+
+// jitteredInterval returns cfg's refresh interval with up to ±jitter()
+// fraction of random jitter applied, so many mosdns instances configured
+// with the same interval don't all refresh the same upstream URL at the
+// same wall-clock tick.
+func jitteredInterval(cfg Config) time.Duration {
+	base := time.Duration(cfg.Interval) * time.Second
+	j := cfg.jitter()
+	if j <= 0 || base <= 0 {
+		return base
+	}
+	delta := (rand.Float64()*2 - 1) * j // in [-j, j]
+	d := time.Duration(float64(base) * (1 + delta))
+	if d <= 0 {
+		return base
+	}
+	return d
+}
+
+// FetchWithRetry calls Fetch, retrying a failed attempt with exponential
+// backoff (1s, 2s, 4s, ... capped at half the refresh interval) up to
+// cfg.maxRetries() times before giving up.
+func FetchWithRetry(cfg Config) (updated bool, err error) {
+	backoffCap := time.Duration(cfg.Interval) * time.Second / 2
+	backoff := retryBaseBackoff
+
+	for attempt := 0; ; attempt++ {
+		updated, err = Fetch(cfg)
+		if err == nil {
+			return updated, nil
+		}
+		if attempt >= cfg.maxRetries() {
+			return false, err
+		}
+
+		if backoffCap > 0 && backoff > backoffCap {
+			backoff = backoffCap
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// RunScheduled calls refresh once every jittered Interval, forever, until
+// stop is closed. A nil stop channel runs until the process exits.
+func RunScheduled(cfg Config, stop <-chan struct{}, refresh func()) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(jitteredInterval(cfg)):
+			refresh()
+		}
+	}
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package remote
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/jedisct1/go-minisign"
+)
+
+// verify checks the downloaded file at path against whatever integrity
+// settings cfg carries. It is a no-op if neither sha256 nor minisign
+// verification is configured.
+func verify(client *http.Client, cfg Config, path string) error {
+	if cfg.SHA256 != "" || cfg.SHA256URL != "" {
+		if err := verifySHA256(client, cfg, path); err != nil {
+			return err
+		}
+	}
+	if cfg.MinisignPubKey != "" && cfg.SigURL != "" {
+		if err := verifyMinisign(client, cfg, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func verifySHA256(client *http.Client, cfg Config, path string) error {
+	want := strings.ToLower(strings.TrimSpace(cfg.SHA256))
+	if want == "" {
+		b, err := fetchBytes(client, cfg.SHA256URL)
+		if err != nil {
+			return fmt.Errorf("failed to fetch sha256_url: %w", err)
+		}
+		// Accept either a bare digest or the common "<digest>  <filename>"
+		// sha256sum(1) output format.
+		fields := strings.Fields(string(b))
+		if len(fields) == 0 {
+			return fmt.Errorf("sha256_url returned an empty response")
+		}
+		want = strings.ToLower(fields[0])
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("failed to hash downloaded file: %w", err)
+	}
+	got := hex.EncodeToString(h.Sum(nil))
+
+	if got != want {
+		return fmt.Errorf("sha256 mismatch: want %s, got %s", want, got)
+	}
+	return nil
+}
+
+func verifyMinisign(client *http.Client, cfg Config, path string) error {
+	pub, err := minisign.NewPublicKey(cfg.MinisignPubKey)
+	if err != nil {
+		return fmt.Errorf("invalid minisign_pub_key: %w", err)
+	}
+
+	sigBytes, err := fetchBytes(client, cfg.SigURL)
+	if err != nil {
+		return fmt.Errorf("failed to fetch sig_url: %w", err)
+	}
+	sig, err := minisign.DecodeSignature(string(sigBytes))
+	if err != nil {
+		return fmt.Errorf("invalid minisign signature: %w", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	ok, err := pub.Verify(b, sig)
+	if err != nil {
+		return fmt.Errorf("failed to verify signature: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("minisign signature verification failed")
+	}
+	return nil
+}
+
+func fetchBytes(client *http.Client, url string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad status: %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package remote
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifySHA256(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(path, []byte("hello mosdns"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	const digest = "2d71ea8dec9edf28223e99ca76a12ac06aa9c90d9636172029b60b5b951fbd4e"
+
+	if err := verifySHA256(&http.Client{}, Config{SHA256: digest}, path); err != nil {
+		t.Errorf("matching digest: got error %v, want nil", err)
+	}
+
+	wrong := "0000000000000000000000000000000000000000000000000000000000000000"
+	if err := verifySHA256(&http.Client{}, Config{SHA256: wrong}, path); err == nil {
+		t.Error("mismatched digest: got nil error, want non-nil")
+	}
+}
+
+// minisignPublicKey and minisignSignature build the minisign formats (the
+// bare base64 public key string that minisign_pub_key takes, and for
+// signatures the untrusted comment / base64 blob / trusted comment / global
+// signature over signature+comment) directly from a freshly generated
+// ed25519 key, so the tests below don't depend on a checked-in fixture that
+// could go stale or on reaching the network.
+func minisignPublicKey(keyID [8]byte, pub ed25519.PublicKey) string {
+	blob := append([]byte{'E', 'd'}, keyID[:]...)
+	blob = append(blob, pub...)
+	return base64.StdEncoding.EncodeToString(blob)
+}
+
+func minisignSignature(keyID [8]byte, priv ed25519.PrivateKey, message []byte, trustedComment string) string {
+	signature := ed25519.Sign(priv, message)
+	sigBlob := append([]byte{'E', 'd'}, keyID[:]...)
+	sigBlob = append(sigBlob, signature...)
+
+	globalSig := ed25519.Sign(priv, append(append([]byte{}, signature...), []byte(trustedComment)...))
+
+	return fmt.Sprintf("untrusted comment: minisign test signature\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(sigBlob),
+		trustedComment,
+		base64.StdEncoding.EncodeToString(globalSig),
+	)
+}
+
+func TestVerifyMinisign(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID := [8]byte{'t', 'e', 's', 't', 'k', 'e', 'y', '1'}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data")
+	content := []byte("hello mosdns")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sig := minisignSignature(keyID, priv, content, "test fixture")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, sig)
+	}))
+	defer srv.Close()
+
+	cfg := Config{MinisignPubKey: minisignPublicKey(keyID, pub), SigURL: srv.URL}
+
+	if err := verifyMinisign(srv.Client(), cfg, path); err != nil {
+		t.Errorf("valid signature: got error %v, want nil", err)
+	}
+
+	tampered := filepath.Join(dir, "tampered")
+	if err := os.WriteFile(tampered, append(content, '!'), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyMinisign(srv.Client(), cfg, tampered); err == nil {
+		t.Error("signature signed over a different file: got nil error, want non-nil")
+	}
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package v2dat reads the protobuf-encoded geosite.dat / geoip.dat files
+// published by v2fly/domain-list-community and v2fly/geoip, converting the
+// tags a caller asks for into the rule syntax mosdns's own matchers already
+// understand.
+package v2dat
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/v2fly/v2ray-core/v5/app/router/routercommon"
+	"google.golang.org/protobuf/proto"
+)
+
+// LoadGeoSiteDomains reads a geosite.dat file and returns every domain
+// entry under the requested tags (case-insensitive), rewritten as mosdns
+// domain-set expressions ("full:", "domain:", "keyword:", "regexp:")
+// suitable for domain.MixMatcher.Add.
+func LoadGeoSiteDomains(path string, tags []string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	list := new(routercommon.GeoSiteList)
+	if err := proto.Unmarshal(b, list); err != nil {
+		return nil, fmt.Errorf("failed to decode geosite list: %w", err)
+	}
+
+	want := toTagSet(tags)
+	var exprs []string
+	for _, site := range list.GetEntry() {
+		if !want[strings.ToUpper(site.GetCountryCode())] {
+			continue
+		}
+		for _, d := range site.GetDomain() {
+			expr, ok := domainExpr(d)
+			if !ok {
+				continue
+			}
+			exprs = append(exprs, expr)
+		}
+	}
+	return exprs, nil
+}
+
+// LoadGeoIPPrefixes reads a geoip.dat file and returns every CIDR entry
+// under the requested tags (case-insensitive) as netip.Prefix.
+func LoadGeoIPPrefixes(path string, tags []string) ([]netip.Prefix, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	list := new(routercommon.GeoIPList)
+	if err := proto.Unmarshal(b, list); err != nil {
+		return nil, fmt.Errorf("failed to decode geoip list: %w", err)
+	}
+
+	want := toTagSet(tags)
+	var prefixes []netip.Prefix
+	for _, geoIP := range list.GetEntry() {
+		if !want[strings.ToUpper(geoIP.GetCountryCode())] {
+			continue
+		}
+		for _, cidr := range geoIP.GetCidr() {
+			addr, ok := netip.AddrFromSlice(cidr.GetIp())
+			if !ok {
+				continue
+			}
+			p := netip.PrefixFrom(addr, int(cidr.GetPrefix()))
+			if !p.IsValid() {
+				continue
+			}
+			prefixes = append(prefixes, p.Masked())
+		}
+	}
+	return prefixes, nil
+}
+
+func toTagSet(tags []string) map[string]bool {
+	want := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		want[strings.ToUpper(t)] = true
+	}
+	return want
+}
+
+// domainExpr maps a v2ray domain entry's Type to the mosdns domain-set
+// expression prefix with the equivalent match semantics: RootDomain (the
+// domain and its subdomains) -> "domain:", Full (exact) -> "full:",
+// Regex -> "regexp:", Plain (substring) -> "keyword:".
+func domainExpr(d *routercommon.Domain) (string, bool) {
+	if d == nil {
+		return "", false
+	}
+	switch d.GetType() {
+	case routercommon.Domain_Plain:
+		return "keyword:" + d.GetValue(), true
+	case routercommon.Domain_Regex:
+		return "regexp:" + d.GetValue(), true
+	case routercommon.Domain_RootDomain:
+		return "domain:" + d.GetValue(), true
+	case routercommon.Domain_Full:
+		return "full:" + d.GetValue(), true
+	default:
+		return "", false
+	}
+}
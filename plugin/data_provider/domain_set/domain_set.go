@@ -22,15 +22,14 @@ package domain_set
 import (
 	"bytes"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
-	"path/filepath"
-	"time"
+	"sync/atomic"
 
 	"github.com/IrineSistiana/mosdns/v5/coremain"
 	"github.com/IrineSistiana/mosdns/v5/pkg/matcher/domain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/remote"
 	"github.com/IrineSistiana/mosdns/v5/plugin/data_provider"
+	"go.uber.org/zap"
 )
 
 const PluginType = "domain_set"
@@ -52,12 +51,11 @@ type Args struct {
 	Sets        []string     `yaml:"sets"`
 	Files       []string     `yaml:"files"`
 	RemoteFiles []RemoteFile `yaml:"remote_files"`
+	DatFiles    []DatFile    `yaml:"dat_files"`
 }
 
 type RemoteFile struct {
-	URL      string `yaml:"url"`
-	Path     string `yaml:"path"`
-	Interval int    `yaml:"interval"` // in seconds
+	remote.Config `yaml:",inline"`
 }
 
 var _ data_provider.DomainMatcherProvider = (*DomainSet)(nil)
@@ -78,20 +76,33 @@ func NewDomainSet(bp *coremain.BP, args *Args) (*DomainSet, error) {
 	if err := LoadExpsAndFiles(args.Exps, args.Files, m); err != nil {
 		return nil, err
 	}
+	if m.Len() > 0 {
+		ds.mg = append(ds.mg, m)
+	}
 
-	// Handle remote files
+	// Handle remote files. Each remote file gets its own atomic slot so a
+	// reload of one file can never be observed as a partial update of another.
 	for _, rf := range args.RemoteFiles {
-		if err := LoadRemoteFile(rf, m); err != nil {
+		rm, err := newRemoteMatcher(rf)
+		if err != nil {
 			return nil, fmt.Errorf("failed to load remote file %s: %w", rf.URL, err)
 		}
-		// Start background update goroutine if interval is set
+		ds.mg = append(ds.mg, rm)
 		if rf.Interval > 0 {
-			go updateRemoteFile(rf, m)
+			go updateRemoteFile(rf, rm, bp.L())
 		}
 	}
 
-	if m.Len() > 0 {
-		ds.mg = append(ds.mg, m)
+	// Handle v2ray geosite.dat files, same atomic-slot-per-file treatment.
+	for _, df := range args.DatFiles {
+		rm, err := newDatMatcher(df)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load dat file %s: %w", df.Path, err)
+		}
+		ds.mg = append(ds.mg, rm)
+		if df.Interval > 0 {
+			go updateDatFile(df, rm, bp.L())
+		}
 	}
 
 	for _, tag := range args.Sets {
@@ -147,75 +158,68 @@ func LoadFile(f string, m *domain.MixMatcher[struct{}]) error {
 	return nil
 }
 
-func LoadRemoteFile(rf RemoteFile, m *domain.MixMatcher[struct{}]) error {
-	// Create directory if it doesn't exist
-	if dir := filepath.Dir(rf.Path); dir != "" {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return fmt.Errorf("failed to create directory %s: %w", dir, err)
-		}
-	}
+// remoteMatcher is a domain.Matcher[struct{}] backed by an atomic pointer.
+// The updater goroutine builds a brand-new MixMatcher off to the side and
+// swaps the pointer in one atomic store, so concurrent Match calls either see
+// the old, fully-built matcher or the new one, never a half-updated one.
+type remoteMatcher struct {
+	cur atomic.Pointer[domain.MixMatcher[struct{}]]
+}
 
-	// Download file if it doesn't exist
-	if _, err := os.Stat(rf.Path); os.IsNotExist(err) {
-		if err := downloadFile(rf.URL, rf.Path); err != nil {
-			return err
-		}
-	}
+func (r *remoteMatcher) Match(s string) (struct{}, bool) {
+	return r.cur.Load().Match(s)
+}
 
-	// Load the file first
-	if err := LoadFile(rf.Path, m); err != nil {
-		return err
+func (r *remoteMatcher) store(m *domain.MixMatcher[struct{}]) {
+	r.cur.Store(m)
+}
+
+// newRemoteMatcher fetches (if necessary) and loads rf, returning a
+// remoteMatcher ready to be used as a domain.Matcher[struct{}].
+func newRemoteMatcher(rf RemoteFile) (*remoteMatcher, error) {
+	if _, err := remote.FetchWithRetry(rf.Config); err != nil {
+		return nil, fmt.Errorf("failed to fetch remote file: %w", err)
 	}
 
-	// Then update it once to ensure we have the latest version
-	if err := downloadFile(rf.URL, rf.Path); err != nil {
-		return fmt.Errorf("failed to update remote file: %w", err)
+	m := domain.NewDomainMixMatcher()
+	if err := LoadFile(rf.Path, m); err != nil {
+		return nil, err
 	}
 
-	// Reload with updated content
-	return LoadFile(rf.Path, m)
+	rm := &remoteMatcher{}
+	rm.store(m)
+	return rm, nil
 }
 
-func updateRemoteFile(rf RemoteFile, m *domain.MixMatcher[struct{}]) {
-	ticker := time.NewTicker(time.Duration(rf.Interval) * time.Second)
-	defer ticker.Stop()
+func updateRemoteFile(rf RemoteFile, rm *remoteMatcher, log *zap.Logger) {
+	remote.RunScheduled(rf.Config, nil, func() {
+		updated, err := remote.FetchWithRetry(rf.Config)
+		if err != nil {
+			log.Warn("failed to refresh remote domain file", zap.String("url", rf.URL), zap.Error(err))
+			return
+		}
+		if !updated {
+			// A 304 confirming the cached copy is still current, the
+			// in-memory matcher is left untouched.
+			return
+		}
 
-	for range ticker.C {
-		// Create a new matcher for the updated rules
 		newMatcher := domain.NewDomainMixMatcher()
-		if err := downloadFile(rf.URL, rf.Path); err != nil {
-			continue
-		}
 		if err := LoadFile(rf.Path, newMatcher); err != nil {
-			continue
+			log.Warn("failed to load refreshed remote domain file", zap.String("path", rf.Path), zap.Error(err))
+			return
 		}
-
-		// Replace the old matcher's internal data with the new one
-		*m = *newMatcher
-	}
+		rm.store(newMatcher)
+	})
 }
 
-func downloadFile(url, filepath string) error {
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("failed to download file: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status: %s", resp.Status)
-	}
-
-	out, err := os.Create(filepath)
-	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
-	}
-	defer out.Close()
+type MatcherGroup []domain.Matcher[struct{}]
 
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
+func (mg MatcherGroup) Match(s string) (struct{}, bool) {
+	for _, m := range mg {
+		if v, ok := m.Match(s); ok {
+			return v, true
+		}
 	}
-
-	return nil
+	return struct{}{}, false
 }
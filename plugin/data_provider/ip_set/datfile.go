@@ -0,0 +1,94 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package ip_set
+
+import (
+	"fmt"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/matcher/netlist"
+	"github.com/IrineSistiana/mosdns/v5/pkg/remote"
+	"github.com/IrineSistiana/mosdns/v5/pkg/v2dat"
+	"go.uber.org/zap"
+)
+
+// DatFile loads selected tag groups out of a v2fly/geoip "geoip.dat" file,
+// e.g. tags: [cn, private]. URL/Interval are optional: a dat file with no
+// URL is just a local file mosdns reloads on Interval, or loads once if
+// Interval is also 0.
+type DatFile struct {
+	remote.Config `yaml:",inline"`
+	Tags          []string `yaml:"tags"`
+}
+
+func buildDatList(df DatFile) (*netlist.List, error) {
+	prefixes, err := v2dat.LoadGeoIPPrefixes(df.Path, df.Tags)
+	if err != nil {
+		return nil, err
+	}
+	l := netlist.NewList()
+	for _, p := range prefixes {
+		l.Append(p)
+	}
+	l.Sort()
+	return l, nil
+}
+
+// newDatMatcher fetches (if df.URL is set) and parses df, returning a
+// remoteMatcher ready to be used as a netlist.Matcher.
+func newDatMatcher(df DatFile) (*remoteMatcher, error) {
+	if df.URL != "" {
+		if _, err := remote.FetchWithRetry(df.Config); err != nil {
+			return nil, fmt.Errorf("failed to fetch dat file: %w", err)
+		}
+	}
+
+	l, err := buildDatList(df)
+	if err != nil {
+		return nil, err
+	}
+
+	rm := &remoteMatcher{}
+	rm.store(l)
+	return rm, nil
+}
+
+func updateDatFile(df DatFile, rm *remoteMatcher, log *zap.Logger) {
+	remote.RunScheduled(df.Config, nil, func() {
+		if df.URL != "" {
+			updated, err := remote.FetchWithRetry(df.Config)
+			if err != nil {
+				log.Warn("failed to refresh dat file", zap.String("url", df.URL), zap.Error(err))
+				return
+			}
+			if !updated {
+				return
+			}
+		}
+
+		// With no URL this is a local file some other process (e.g. a cron
+		// job) keeps up to date; reload it from disk every interval.
+		newList, err := buildDatList(df)
+		if err != nil {
+			log.Warn("failed to load refreshed dat file", zap.String("path", df.Path), zap.Error(err))
+			return
+		}
+		rm.store(newList)
+	})
+}
@@ -22,17 +22,16 @@ package ip_set
 import (
 	"bytes"
 	"fmt"
-	"io"
-	"net/http"
 	"net/netip"
 	"os"
-	"path/filepath"
 	"strings"
-	"time"
+	"sync/atomic"
 
 	"github.com/IrineSistiana/mosdns/v5/coremain"
 	"github.com/IrineSistiana/mosdns/v5/pkg/matcher/netlist"
+	"github.com/IrineSistiana/mosdns/v5/pkg/remote"
 	"github.com/IrineSistiana/mosdns/v5/plugin/data_provider"
+	"go.uber.org/zap"
 )
 
 const PluginType = "ip_set"
@@ -50,12 +49,12 @@ type Args struct {
 	Sets        []string     `yaml:"sets"`
 	Files       []string     `yaml:"files"`
 	RemoteFiles []RemoteFile `yaml:"remote_files"`
+	MMDBFiles   []MMDBFile   `yaml:"mmdb_files"`
+	DatFiles    []DatFile    `yaml:"dat_files"`
 }
 
 type RemoteFile struct {
-	URL      string `yaml:"url"`
-	Path     string `yaml:"path"`
-	Interval int    `yaml:"interval"` // in seconds
+	remote.Config `yaml:",inline"`
 }
 
 var _ data_provider.IPMatcherProvider = (*IPSet)(nil)
@@ -75,22 +74,47 @@ func NewIPSet(bp *coremain.BP, args *Args) (*IPSet, error) {
 	if err := LoadFromIPsAndFiles(args.IPs, args.Files, l); err != nil {
 		return nil, err
 	}
+	l.Sort()
+	if l.Len() > 0 {
+		p.mg = append(p.mg, l)
+	}
 
-	// Handle remote files
+	// Handle remote files. Each remote file gets its own atomic slot so a
+	// reload of one file can never be observed as a partial update of another.
 	for _, rf := range args.RemoteFiles {
-		if err := LoadFromRemoteFile(rf, l); err != nil {
+		rm, err := newRemoteMatcher(rf)
+		if err != nil {
 			return nil, fmt.Errorf("failed to load remote file %s: %w", rf.URL, err)
 		}
-		// Start background update goroutine if interval is set
+		p.mg = append(p.mg, rm)
 		if rf.Interval > 0 {
-			go updateRemoteFile(rf, l)
+			go updateRemoteFile(rf, rm, bp.L())
 		}
 	}
 
-	l.Sort()
-	if l.Len() > 0 {
-		p.mg = append(p.mg, l)
+	for _, mf := range args.MMDBFiles {
+		mm, err := newMMDBMatcher(mf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load mmdb file %s: %w", mf.Path, err)
+		}
+		p.mg = append(p.mg, mm)
+		if mf.Interval > 0 {
+			go updateMMDBFile(mf, mm, bp.L())
+		}
 	}
+
+	// Handle v2ray geoip.dat files, same atomic-slot-per-file treatment.
+	for _, df := range args.DatFiles {
+		rm, err := newDatMatcher(df)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load dat file %s: %w", df.Path, err)
+		}
+		p.mg = append(p.mg, rm)
+		if df.Interval > 0 {
+			go updateDatFile(df, rm, bp.L())
+		}
+	}
+
 	for _, tag := range args.Sets {
 		provider, _ := bp.M().GetPlugin(tag).(data_provider.IPMatcherProvider)
 		if provider == nil {
@@ -155,73 +179,61 @@ func LoadFromFile(f string, l *netlist.List) error {
 	return nil
 }
 
-func LoadFromRemoteFile(rf RemoteFile, l *netlist.List) error {
-	// Create directory if it doesn't exist
-	if dir := filepath.Dir(rf.Path); dir != "" {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return fmt.Errorf("failed to create directory %s: %w", dir, err)
-		}
-	}
+// remoteMatcher is a netlist.Matcher backed by an atomic pointer. The
+// updater goroutine builds a brand-new, fully sorted *netlist.List off to
+// the side and swaps the pointer in one atomic store, so concurrent Match
+// calls either see the old list or the new one, never a half-built one.
+type remoteMatcher struct {
+	cur atomic.Pointer[netlist.List]
+}
 
-	// Download file if it doesn't exist
-	if _, err := os.Stat(rf.Path); os.IsNotExist(err) {
-		if err := downloadFile(rf.URL, rf.Path); err != nil {
-			return err
-		}
-	}
+func (r *remoteMatcher) Match(addr netip.Addr) bool {
+	return r.cur.Load().Match(addr)
+}
+
+func (r *remoteMatcher) store(l *netlist.List) {
+	r.cur.Store(l)
+}
 
-	// Load the file
-	LoadFromFile(rf.Path, l)
+// newRemoteMatcher fetches (if necessary) and loads rf, returning a
+// remoteMatcher ready to be used as a netlist.Matcher.
+func newRemoteMatcher(rf RemoteFile) (*remoteMatcher, error) {
+	if _, err := remote.FetchWithRetry(rf.Config); err != nil {
+		return nil, fmt.Errorf("failed to fetch remote file: %w", err)
+	}
 
-	// Then update it once to ensure we have the latest version
-	if err := downloadFile(rf.URL, rf.Path); err != nil {
-		return err
+	l := netlist.NewList()
+	if err := LoadFromFile(rf.Path, l); err != nil {
+		return nil, err
 	}
+	l.Sort()
 
-	// Reload with updated content
-	return LoadFromFile(rf.Path, l)
+	rm := &remoteMatcher{}
+	rm.store(l)
+	return rm, nil
 }
 
-func updateRemoteFile(rf RemoteFile, l *netlist.List) {
-	ticker := time.NewTicker(time.Duration(rf.Interval) * time.Second)
-	defer ticker.Stop()
+func updateRemoteFile(rf RemoteFile, rm *remoteMatcher, log *zap.Logger) {
+	remote.RunScheduled(rf.Config, nil, func() {
+		updated, err := remote.FetchWithRetry(rf.Config)
+		if err != nil {
+			log.Warn("failed to refresh remote ip file", zap.String("url", rf.URL), zap.Error(err))
+			return
+		}
+		if !updated {
+			// A 304 confirming the cached copy is still current, the
+			// in-memory matcher is left untouched.
+			return
+		}
 
-	for range ticker.C {
 		newList := netlist.NewList()
-		if err := downloadFile(rf.URL, rf.Path); err != nil {
-			continue
-		}
 		if err := LoadFromFile(rf.Path, newList); err != nil {
-			continue
+			log.Warn("failed to load refreshed remote ip file", zap.String("path", rf.Path), zap.Error(err))
+			return
 		}
 		newList.Sort()
-		*l = *newList // Replace the old list with the new one
-	}
-}
-
-func downloadFile(url, filepath string) error {
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("failed to download file: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status: %s", resp.Status)
-	}
-
-	out, err := os.Create(filepath)
-	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
-	}
-	defer out.Close()
-
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
-	}
-
-	return nil
+		rm.store(newList)
+	})
 }
 
 type MatcherGroup []netlist.Matcher
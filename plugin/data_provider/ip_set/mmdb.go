@@ -0,0 +1,147 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package ip_set
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/remote"
+	"github.com/oschwald/maxminddb-golang"
+	"go.uber.org/zap"
+)
+
+// mmdbCloseDelay is how long a reader replaced by reload is kept open
+// before being closed, to let any Lookup already in flight on it finish.
+// maxminddb.Reader mmaps the file; unlike the matcher types elsewhere in
+// this package, it is not safe to just drop the old value and let the GC
+// collect it, since the mapping and its fd are only released by Close.
+const mmdbCloseDelay = 30 * time.Second
+
+// MMDBFile loads IPs out of a MaxMind GeoLite2/GeoIP2 database instead of a
+// flat text file. A prefix matches if the record's country or ASN is in the
+// configured filter set. An empty filter set never matches. URL/Interval are
+// optional: an mmdb file with no URL is just a local file mosdns reloads on
+// Interval, or loads once if Interval is also 0.
+type MMDBFile struct {
+	remote.Config `yaml:",inline"`
+	CountryCodes  []string `yaml:"country_codes"`
+	ASN           []string `yaml:"asn"`
+}
+
+type mmdbRecord struct {
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	AutonomousSystemNumber uint `maxminddb:"autonomous_system_number"`
+}
+
+// mmdbMatcher is a netlist.Matcher backed by an atomic pointer to an open
+// *maxminddb.Reader, following the same swap-on-reload wiring as
+// remoteMatcher: the updater opens a brand-new reader off to the side and
+// only then publishes it, so a lookup never observes a half-loaded database.
+type mmdbMatcher struct {
+	countryCodes []string
+	asn          []string
+	reader       atomic.Pointer[maxminddb.Reader]
+}
+
+func (m *mmdbMatcher) Match(addr netip.Addr) bool {
+	reader := m.reader.Load()
+	if reader == nil {
+		return false
+	}
+
+	var record mmdbRecord
+	if err := reader.Lookup(net.IP(addr.AsSlice()), &record); err != nil {
+		return false
+	}
+
+	for _, cc := range m.countryCodes {
+		if strings.EqualFold(cc, record.Country.IsoCode) {
+			return true
+		}
+	}
+	asn := fmt.Sprintf("AS%d", record.AutonomousSystemNumber)
+	for _, want := range m.asn {
+		if strings.EqualFold(want, asn) {
+			return true
+		}
+	}
+	return false
+}
+
+// store publishes reader and, if it replaces a previous one, schedules the
+// previous reader to be closed once mmdbCloseDelay has elapsed.
+func (m *mmdbMatcher) store(reader *maxminddb.Reader) {
+	old := m.reader.Swap(reader)
+	if old != nil {
+		time.AfterFunc(mmdbCloseDelay, func() {
+			old.Close()
+		})
+	}
+}
+
+// newMMDBMatcher fetches (if mf.URL is set) and opens mf, returning an
+// mmdbMatcher ready to be used as a netlist.Matcher.
+func newMMDBMatcher(mf MMDBFile) (*mmdbMatcher, error) {
+	if mf.URL != "" {
+		if _, err := remote.FetchWithRetry(mf.Config); err != nil {
+			return nil, fmt.Errorf("failed to fetch mmdb file: %w", err)
+		}
+	}
+
+	reader, err := maxminddb.Open(mf.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open mmdb file: %w", err)
+	}
+
+	m := &mmdbMatcher{countryCodes: mf.CountryCodes, asn: mf.ASN}
+	m.store(reader)
+	return m, nil
+}
+
+func updateMMDBFile(mf MMDBFile, m *mmdbMatcher, log *zap.Logger) {
+	remote.RunScheduled(mf.Config, nil, func() {
+		if mf.URL != "" {
+			updated, err := remote.FetchWithRetry(mf.Config)
+			if err != nil {
+				log.Warn("failed to refresh mmdb file", zap.String("url", mf.URL), zap.Error(err))
+				return
+			}
+			if !updated {
+				return
+			}
+		}
+
+		// With no URL this is a local file some other process (e.g. a cron
+		// job) keeps up to date; reload it from disk every interval.
+		reader, err := maxminddb.Open(mf.Path)
+		if err != nil {
+			log.Warn("failed to open refreshed mmdb file", zap.String("path", mf.Path), zap.Error(err))
+			return
+		}
+		m.store(reader)
+	})
+}